@@ -20,21 +20,31 @@ import (
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/client"
+	"golang.org/x/time/rate"
 
+	"flashcat.cloud/categraf/config"
 	"flashcat.cloud/categraf/logs/util/containers/providers"
 	"flashcat.cloud/categraf/pkg/cache"
 	"flashcat.cloud/categraf/pkg/retry"
 )
 
+// maxConcurrentStatsStreams bounds how many ContainerStats streams DockerUtil
+// will keep open against the daemon at once, so a host with hundreds of
+// containers can't overwhelm dockerd the way an unbounded fan-out of stats
+// streams would.
+const maxConcurrentStatsStreams = 50
+
 // DockerUtil wraps interactions with a local docker API.
 type DockerUtil struct {
 	// used to setup the DockerUtil
 	initRetry retry.Retrier
 
 	sync.Mutex
-	cfg          *Config
-	cli          *client.Client
-	queryTimeout time.Duration
+	cfg            *Config
+	cli            ContainerRuntime
+	queryTimeout   time.Duration
+	inspectTimeout time.Duration
+	statsTimeout   time.Duration
 	// tracks the last time we invalidate our internal caches
 	lastInvalidate time.Time
 	// networkMappings by container id
@@ -43,14 +53,25 @@ type DockerUtil struct {
 	imageNameBySha map[string]string
 	// event subscribers and state
 	eventState *eventStreamState
+	// guards how fast StreamContainerStats can open new streams
+	statsLimiter *rate.Limiter
+	// statsSem is a counting semaphore bounding how many ContainerStats
+	// streams can be open against the daemon at once, sized
+	// maxConcurrentStatsStreams; a stream holds its slot until it unwinds.
+	statsSem      chan struct{}
+	statsInFlight int32
+	statsDropped  uint64
+	closeOnce     sync.Once
 }
 
 // init makes an empty DockerUtil bootstrap itself.
 // This is not exposed as public API but is called by the retrier embed.
 func (d *DockerUtil) init() error {
-	// TODO
-	// d.queryTimeout = config.GetDuration("docker_query_timeout") * time.Second
-	d.queryTimeout = 5 * time.Second
+	// Info/ContainerList are cheap; inspect and stats/event replays are not,
+	// so each gets its own configurable default instead of sharing one.
+	d.queryTimeout = configDockerTimeout("docker_query_timeout", 5*time.Second)
+	d.inspectTimeout = configDockerTimeout("docker_inspect_timeout", 5*time.Second)
+	d.statsTimeout = configDockerTimeout("docker_stats_timeout", 5*time.Second)
 
 	// Major failure risk is here, do that first
 	ctx, cancel := context.WithTimeout(context.Background(), d.queryTimeout)
@@ -72,12 +93,37 @@ func (d *DockerUtil) init() error {
 	d.imageNameBySha = make(map[string]string)
 	d.lastInvalidate = time.Now()
 	d.eventState = newEventStreamState()
+	// Allow bursts up to the concurrency cap, then throttle new stream opens
+	// to 5/s so a flood of plugin restarts can't hammer the daemon.
+	d.statsLimiter = rate.NewLimiter(rate.Limit(5), maxConcurrentStatsStreams)
+	// Separately cap how many of those streams can be open at once: the rate
+	// limiter only smooths the open rate, it says nothing about how long a
+	// stream stays open once accepted.
+	d.statsSem = make(chan struct{}, maxConcurrentStatsStreams)
 
 	return nil
 }
 
-// ConnectToDocker connects to docker and negotiates the API version
-func ConnectToDocker(ctx context.Context) (*client.Client, error) {
+// ConnectToDocker connects to the local container runtime and negotiates the
+// API version. It targets the Docker daemon first and transparently falls
+// back to a Podman socket (speaking Podman's Docker-compatible REST API) when
+// the Docker socket isn't present or refuses connections, so the rest of
+// DockerUtil never has to
+// know which runtime it is talking to.
+func ConnectToDocker(ctx context.Context) (ContainerRuntime, error) {
+	cli, err := connectToDockerDaemon(ctx)
+	if err == nil {
+		return cli, nil
+	}
+	if !isDockerSocketMissing(err) {
+		return nil, err
+	}
+
+	log.Println("Docker socket not found, falling back to Podman")
+	return ConnectToPodman(ctx)
+}
+
+func connectToDockerDaemon(ctx context.Context) (*client.Client, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, err
@@ -94,9 +140,38 @@ func ConnectToDocker(ctx context.Context) (*client.Client, error) {
 	return cli, nil
 }
 
+// isDockerSocketMissing reports whether err looks like the Docker daemon
+// simply isn't there, as opposed to e.g. a permissions error we want
+// surfaced to the caller as-is.
+func isDockerSocketMissing(err error) bool {
+	return strings.Contains(err.Error(), "no such file or directory") ||
+		strings.Contains(err.Error(), "connection refused")
+}
+
+// configDockerTimeout reads a docker_*_timeout config entry, which
+// config.GetDuration already parses into a time.Duration, and falls back to
+// def when it isn't set.
+func configDockerTimeout(key string, def time.Duration) time.Duration {
+	if v := config.GetDuration(key); v > 0 {
+		return v
+	}
+	return def
+}
+
+// withTimeout wraps ctx with def unless the caller already attached a
+// deadline of its own. This lets long-lived callers (the event stream, stats
+// streams, log tailing) pass a cancellable parent context without getting
+// truncated at the short default query timeout.
+func withTimeout(ctx context.Context, def time.Duration) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, def)
+}
+
 // Images returns a slice of all images.
 func (d *DockerUtil) Images(ctx context.Context, includeIntermediate bool) ([]types.ImageSummary, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 	images, err := d.cli.ImageList(ctx, types.ImageListOptions{All: includeIntermediate})
 
@@ -110,7 +185,7 @@ func (d *DockerUtil) Images(ctx context.Context, includeIntermediate bool) ([]ty
 func (d *DockerUtil) CountVolumes(ctx context.Context) (int, int, error) {
 	attachedFilter, _ := buildDockerFilter("dangling", "false")
 	danglingFilter, _ := buildDockerFilter("dangling", "true")
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 
 	attachedVolumes, err := d.cli.VolumeList(ctx, attachedFilter)
@@ -128,13 +203,13 @@ func (d *DockerUtil) CountVolumes(ctx context.Context) (int, int, error) {
 // RawContainerList wraps around the docker client's ContainerList method.
 // Value validation and error handling are the caller's responsibility.
 func (d *DockerUtil) RawContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 	return d.cli.ContainerList(ctx, options)
 }
 
 func (d *DockerUtil) GetHostname(ctx context.Context) (string, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 	info, err := d.cli.Info(ctx)
 	if err != nil {
@@ -146,7 +221,7 @@ func (d *DockerUtil) GetHostname(ctx context.Context) (string, error) {
 // GetStorageStats returns the docker global storage stats if available
 // or ErrStorageStatsNotAvailable
 func (d *DockerUtil) GetStorageStats(ctx context.Context) ([]*StorageStats, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 	info, err := d.cli.Info(ctx)
 	if err != nil {
@@ -169,7 +244,7 @@ func (d *DockerUtil) ResolveImageName(ctx context.Context, image string) (string
 	d.Lock()
 	defer d.Unlock()
 	if _, ok := d.imageNameBySha[image]; !ok {
-		ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+		ctx, cancel := withTimeout(ctx, d.inspectTimeout)
 		defer cancel()
 		r, _, err := d.cli.ImageInspectWithRaw(ctx, image)
 		if err != nil {
@@ -245,7 +320,7 @@ func (d *DockerUtil) Inspect(ctx context.Context, id string, withSize bool) (typ
 // ignores the inspect cache, always collecting fresh data from the docker
 // daemon.
 func (d *DockerUtil) InspectNoCache(ctx context.Context, id string, withSize bool) (types.ContainerJSON, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.inspectTimeout)
 	defer cancel()
 
 	container, _, err := d.cli.ContainerInspectWithRaw(ctx, id, withSize)
@@ -277,7 +352,7 @@ func (d *DockerUtil) InspectSelf(ctx context.Context) (types.ContainerJSON, erro
 // AllContainerLabels retrieves all running containers (`docker ps`) and returns
 // a map mapping containerID to container labels as a map[string]string
 func (d *DockerUtil) AllContainerLabels(ctx context.Context) (map[string]map[string]string, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.queryTimeout)
 	defer cancel()
 	containers, err := d.cli.ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
@@ -297,7 +372,7 @@ func (d *DockerUtil) AllContainerLabels(ctx context.Context) (map[string]map[str
 }
 
 func (d *DockerUtil) GetContainerStats(ctx context.Context, containerID string) (*types.StatsJSON, error) {
-	ctx, cancel := context.WithTimeout(ctx, d.queryTimeout)
+	ctx, cancel := withTimeout(ctx, d.statsTimeout)
 	defer cancel()
 	stats, err := d.cli.ContainerStats(ctx, containerID, false)
 	if err != nil {
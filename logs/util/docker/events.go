@@ -0,0 +1,234 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+
+	"flashcat.cloud/categraf/pkg/cache"
+	"flashcat.cloud/categraf/pkg/retry"
+)
+
+// eventSubscriberBuffer is how many events a slow subscriber can fall behind
+// by before we start dropping rather than blocking the dispatch loop.
+const eventSubscriberBuffer = 100
+
+// ContainerEvent is the shape fanned out to subscribers, trimmed down from
+// the raw docker events.Message to what log tailers and plugins actually
+// need.
+type ContainerEvent struct {
+	ContainerID string
+	Type        events.Type
+	Action      string
+	Time        time.Time
+}
+
+type eventSubscriber struct {
+	ch chan ContainerEvent
+}
+
+// eventStreamState tracks the goroutine consuming the docker event stream and
+// the subscribers fanned out to it. DockerUtil embeds a pointer to it so the
+// field stays meaningful even before the stream is started.
+type eventStreamState struct {
+	sync.Mutex
+	subscribers map[string]*eventSubscriber
+	dropped     map[string]uint64
+	cancel      context.CancelFunc
+	started     bool
+}
+
+func newEventStreamState() *eventStreamState {
+	return &eventStreamState{
+		subscribers: make(map[string]*eventSubscriber),
+		dropped:     make(map[string]uint64),
+	}
+}
+
+// SubscribeToEvents registers a new named subscriber and lazily starts the
+// event stream goroutine on first use. The returned channel is closed when
+// the subscriber unsubscribes or DockerUtil is closed.
+func (d *DockerUtil) SubscribeToEvents(name string) (<-chan ContainerEvent, error) {
+	d.eventState.Lock()
+	defer d.eventState.Unlock()
+
+	if _, ok := d.eventState.subscribers[name]; ok {
+		return nil, fmt.Errorf("docker event subscriber %q is already registered", name)
+	}
+
+	sub := &eventSubscriber{ch: make(chan ContainerEvent, eventSubscriberBuffer)}
+	d.eventState.subscribers[name] = sub
+
+	if !d.eventState.started {
+		ctx, cancel := context.WithCancel(context.Background())
+		d.eventState.cancel = cancel
+		d.eventState.started = true
+		go d.runEventStream(ctx)
+	}
+
+	return sub.ch, nil
+}
+
+// UnsubscribeFromEvents removes a subscriber and closes its channel.
+func (d *DockerUtil) UnsubscribeFromEvents(name string) {
+	d.eventState.Lock()
+	defer d.eventState.Unlock()
+
+	if sub, ok := d.eventState.subscribers[name]; ok {
+		close(sub.ch)
+		delete(d.eventState.subscribers, name)
+		delete(d.eventState.dropped, name)
+	}
+}
+
+// DroppedEventCount returns the number of events dropped for name because its
+// subscriber channel was full.
+func (d *DockerUtil) DroppedEventCount(name string) uint64 {
+	d.eventState.Lock()
+	defer d.eventState.Unlock()
+	return d.eventState.dropped[name]
+}
+
+// runEventStream consumes the docker event stream for the lifetime of ctx,
+// using the same retry.Retrier the rest of DockerUtil relies on to reconnect
+// with exponential backoff whenever the stream drops.
+func (d *DockerUtil) runEventStream(ctx context.Context) {
+	var retrier retry.Retrier
+	err := retrier.SetupRetrier(&retry.Config{
+		Name:              "docker-events",
+		AttemptMethod:     func() error { return d.consumeEventStream(ctx) },
+		Strategy:          retry.Backoff,
+		InitialRetryDelay: time.Second,
+		MaxRetryDelay:     time.Minute,
+	})
+	if err != nil {
+		log.Printf("unable to set up docker event stream retrier: %s", err)
+		return
+	}
+
+	for ctx.Err() == nil {
+		if err := retrier.TriggerRetry(); err != nil {
+			log.Printf("docker event stream error, reconnecting at %s: %s", retrier.NextRetry(), err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(retrier.NextRetry())):
+		}
+	}
+}
+
+// consumeEventStream reads from a single docker event stream connection
+// until it ends or ctx is cancelled, dispatching and invalidating caches for
+// every message it sees.
+func (d *DockerUtil) consumeEventStream(ctx context.Context) error {
+	msgs, errs := d.cli.Events(ctx, types.EventsOptions{})
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-msgs:
+			if !ok {
+				return fmt.Errorf("docker event stream closed")
+			}
+			d.handleEvent(msg)
+		case err, ok := <-errs:
+			if !ok || err == nil {
+				continue
+			}
+			return err
+		}
+	}
+}
+
+// handleEvent invalidates the caches affected by msg and fans it out to
+// subscribers.
+func (d *DockerUtil) handleEvent(msg events.Message) {
+	switch msg.Type {
+	case events.ContainerEventType:
+		switch msg.Action {
+		case "start", "die", "destroy":
+			d.invalidateContainerCache(msg.Actor.ID)
+		}
+	case events.ImageEventType:
+		switch msg.Action {
+		case "tag", "untag", "delete":
+			d.invalidateImageCache(msg.Actor.ID)
+		}
+	case events.NetworkEventType:
+		switch msg.Action {
+		case "connect", "disconnect":
+			d.invalidateContainerCache(msg.Actor.Attributes["container"])
+		}
+	}
+
+	d.dispatchEvent(msg)
+}
+
+// invalidateContainerCache drops id from the network mapping and inspect
+// caches as soon as an event for that container arrives. The 10s TTL that
+// Inspect puts on its cache entries is not removed and still applies.
+func (d *DockerUtil) invalidateContainerCache(id string) {
+	if id == "" {
+		return
+	}
+
+	d.Lock()
+	delete(d.networkMappings, id)
+	d.Unlock()
+
+	cache.Cache.Delete(GetInspectCacheKey(id, false))
+	cache.Cache.Delete(GetInspectCacheKey(id, true))
+}
+
+// invalidateImageCache drops any imageNameBySha entries referring to id,
+// whether id is the sha key itself or the resolved name.
+func (d *DockerUtil) invalidateImageCache(id string) {
+	if id == "" {
+		return
+	}
+
+	d.Lock()
+	defer d.Unlock()
+	for sha, name := range d.imageNameBySha {
+		if sha == id || name == id {
+			delete(d.imageNameBySha, sha)
+		}
+	}
+}
+
+// dispatchEvent fans msg out to every subscriber, dropping it for any
+// subscriber whose channel is full rather than blocking the stream.
+func (d *DockerUtil) dispatchEvent(msg events.Message) {
+	evt := ContainerEvent{
+		ContainerID: msg.Actor.ID,
+		Type:        msg.Type,
+		Action:      string(msg.Action),
+		Time:        time.Unix(0, msg.TimeNano),
+	}
+
+	d.eventState.Lock()
+	defer d.eventState.Unlock()
+	for name, sub := range d.eventState.subscribers {
+		select {
+		case sub.ch <- evt:
+		default:
+			d.eventState.dropped[name]++
+			log.Printf("dropping docker event for subscriber %q: channel full", name)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+// addTestSubscriber registers name directly against eventState, bypassing
+// SubscribeToEvents so the test doesn't spin up the real event stream
+// goroutine (which needs a live ContainerRuntime).
+func addTestSubscriber(d *DockerUtil, name string) <-chan ContainerEvent {
+	d.eventState.Lock()
+	defer d.eventState.Unlock()
+	sub := &eventSubscriber{ch: make(chan ContainerEvent, eventSubscriberBuffer)}
+	d.eventState.subscribers[name] = sub
+	return sub.ch
+}
+
+func newTestDockerUtilForEvents() *DockerUtil {
+	return &DockerUtil{
+		eventState:      newEventStreamState(),
+		networkMappings: make(map[string][]dockerNetwork),
+		imageNameBySha:  make(map[string]string),
+	}
+}
+
+func TestDispatchEventDeliversToSubscribers(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	ch := addTestSubscriber(d, "sub")
+
+	now := time.Unix(100, 0)
+	d.dispatchEvent(events.Message{
+		Type:     events.ContainerEventType,
+		Action:   "start",
+		TimeNano: now.UnixNano(),
+		Actor:    events.Actor{ID: "abc123"},
+	})
+
+	select {
+	case evt := <-ch:
+		if evt.ContainerID != "abc123" || evt.Action != "start" || evt.Type != events.ContainerEventType {
+			t.Errorf("unexpected event: %+v", evt)
+		}
+		if !evt.Time.Equal(now) {
+			t.Errorf("Time = %v, want %v", evt.Time, now)
+		}
+	default:
+		t.Fatal("expected event to be delivered")
+	}
+
+	if dropped := d.DroppedEventCount("sub"); dropped != 0 {
+		t.Errorf("DroppedEventCount = %d, want 0", dropped)
+	}
+}
+
+func TestDispatchEventDropsWhenSubscriberChannelIsFull(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	addTestSubscriber(d, "sub")
+
+	for i := 0; i < eventSubscriberBuffer; i++ {
+		d.dispatchEvent(events.Message{Type: events.ContainerEventType, Action: "start"})
+	}
+	if dropped := d.DroppedEventCount("sub"); dropped != 0 {
+		t.Fatalf("DroppedEventCount before overflow = %d, want 0", dropped)
+	}
+
+	d.dispatchEvent(events.Message{Type: events.ContainerEventType, Action: "start"})
+	if dropped := d.DroppedEventCount("sub"); dropped != 1 {
+		t.Errorf("DroppedEventCount after overflow = %d, want 1", dropped)
+	}
+}
+
+func TestUnsubscribeFromEventsResetsDropCount(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	addTestSubscriber(d, "sub")
+
+	for i := 0; i < eventSubscriberBuffer+1; i++ {
+		d.dispatchEvent(events.Message{Type: events.ContainerEventType, Action: "start"})
+	}
+	if dropped := d.DroppedEventCount("sub"); dropped == 0 {
+		t.Fatalf("expected some drops before unsubscribe")
+	}
+
+	d.UnsubscribeFromEvents("sub")
+	if dropped := d.DroppedEventCount("sub"); dropped != 0 {
+		t.Errorf("DroppedEventCount after unsubscribe = %d, want 0", dropped)
+	}
+}
+
+func TestInvalidateContainerCacheDropsNetworkMapping(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	d.networkMappings["abc123"] = []dockerNetwork{{}}
+
+	d.invalidateContainerCache("abc123")
+
+	if _, ok := d.networkMappings["abc123"]; ok {
+		t.Errorf("expected networkMappings entry for abc123 to be removed")
+	}
+}
+
+func TestInvalidateContainerCacheIgnoresEmptyID(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	d.networkMappings["abc123"] = []dockerNetwork{{}}
+
+	d.invalidateContainerCache("")
+
+	if _, ok := d.networkMappings["abc123"]; !ok {
+		t.Errorf("expected unrelated networkMappings entry to be left alone")
+	}
+}
+
+func TestInvalidateImageCacheDropsMatchingShaAndName(t *testing.T) {
+	d := newTestDockerUtilForEvents()
+	d.imageNameBySha["sha256:abc"] = "myimage:latest"
+	d.imageNameBySha["sha256:def"] = "otherimage:latest"
+
+	d.invalidateImageCache("sha256:abc")
+	if _, ok := d.imageNameBySha["sha256:abc"]; ok {
+		t.Errorf("expected sha256:abc to be invalidated by its own key")
+	}
+	if _, ok := d.imageNameBySha["sha256:def"]; !ok {
+		t.Errorf("expected unrelated sha256:def entry to be left alone")
+	}
+
+	d.invalidateImageCache("otherimage:latest")
+	if _, ok := d.imageNameBySha["sha256:def"]; ok {
+		t.Errorf("expected sha256:def to be invalidated by its resolved name")
+	}
+}
+
+func TestHandleEventInvalidatesContainerCacheOnLifecycleActions(t *testing.T) {
+	for _, action := range []string{"start", "die", "destroy"} {
+		t.Run(action, func(t *testing.T) {
+			d := newTestDockerUtilForEvents()
+			addTestSubscriber(d, "sub")
+			d.networkMappings["abc123"] = []dockerNetwork{{}}
+
+			d.handleEvent(events.Message{
+				Type:   events.ContainerEventType,
+				Action: events.Action(action),
+				Actor:  events.Actor{ID: "abc123"},
+			})
+
+			if _, ok := d.networkMappings["abc123"]; ok {
+				t.Errorf("expected networkMappings entry to be invalidated on container %q", action)
+			}
+		})
+	}
+
+	t.Run("other container actions are left alone", func(t *testing.T) {
+		d := newTestDockerUtilForEvents()
+		d.networkMappings["abc123"] = []dockerNetwork{{}}
+
+		d.handleEvent(events.Message{
+			Type:   events.ContainerEventType,
+			Action: "exec_create",
+			Actor:  events.Actor{ID: "abc123"},
+		})
+
+		if _, ok := d.networkMappings["abc123"]; !ok {
+			t.Errorf("expected networkMappings entry to survive an unrelated action")
+		}
+	})
+}
+
+func TestHandleEventInvalidatesImageCacheOnTagActions(t *testing.T) {
+	for _, action := range []string{"tag", "untag", "delete"} {
+		t.Run(action, func(t *testing.T) {
+			d := newTestDockerUtilForEvents()
+			d.imageNameBySha["sha256:abc"] = "myimage:latest"
+
+			d.handleEvent(events.Message{
+				Type:   events.ImageEventType,
+				Action: events.Action(action),
+				Actor:  events.Actor{ID: "sha256:abc"},
+			})
+
+			if _, ok := d.imageNameBySha["sha256:abc"]; ok {
+				t.Errorf("expected imageNameBySha entry to be invalidated on image %q", action)
+			}
+		})
+	}
+}
+
+func TestHandleEventInvalidatesContainerCacheOnNetworkActions(t *testing.T) {
+	for _, action := range []string{"connect", "disconnect"} {
+		t.Run(action, func(t *testing.T) {
+			d := newTestDockerUtilForEvents()
+			d.networkMappings["abc123"] = []dockerNetwork{{}}
+
+			d.handleEvent(events.Message{
+				Type:   events.NetworkEventType,
+				Action: events.Action(action),
+				Actor:  events.Actor{Attributes: map[string]string{"container": "abc123"}},
+			})
+
+			if _, ok := d.networkMappings["abc123"]; ok {
+				t.Errorf("expected networkMappings entry to be invalidated on network %q", action)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// podmanAPIVersion is the Docker-compatible API version Podman's REST service
+// speaks; it is unrelated to the version of Podman itself.
+const podmanAPIVersion = "v1.41"
+
+// rootPodmanSocket is where `podman system service` listens by default when
+// run as root. Rootless Podman listens under $XDG_RUNTIME_DIR instead.
+const rootPodmanSocket = "/run/podman/podman.sock"
+
+// podmanClient implements ContainerRuntime against Podman's Docker-compatible
+// REST API (reached over its unix socket) rather than the real dockerd.
+// Podman returns the same `types.ContainerJSON`/`types.ImageSummary` shapes
+// callers already consume, but tags on a handful of Podman-only fields (Pod
+// membership, rootless UID/GID maps) that we fold into Labels so downstream
+// code doesn't need a Podman-aware branch.
+type podmanClient struct {
+	httpClient *http.Client
+	socketPath string
+}
+
+// podmanSocketPath picks the rootless socket under $XDG_RUNTIME_DIR when it
+// exists, falling back to the root socket otherwise.
+func podmanSocketPath() string {
+	if xdg := os.Getenv("XDG_RUNTIME_DIR"); xdg != "" {
+		if candidate := xdg + "/podman/podman.sock"; fileExists(candidate) {
+			return candidate
+		}
+	}
+	return rootPodmanSocket
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// ConnectToPodman connects to a local Podman daemon over its Docker-compatible
+// unix socket. It is used as the fallback runtime by ConnectToDocker when the
+// docker socket itself is not present.
+func ConnectToPodman(ctx context.Context) (ContainerRuntime, error) {
+	socketPath := podmanSocketPath()
+	if !fileExists(socketPath) {
+		return nil, fmt.Errorf("no podman socket found at %s", socketPath)
+	}
+
+	pc := &podmanClient{
+		socketPath: socketPath,
+		httpClient: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+				},
+			},
+		},
+	}
+
+	if _, err := pc.Info(ctx); err != nil {
+		return nil, fmt.Errorf("unable to reach podman socket %s: %w", socketPath, err)
+	}
+
+	log.Println("Successfully connected to Podman server")
+	return pc, nil
+}
+
+// do issues a request against the Docker-compatible podman API and returns
+// the raw response body, decoding it into out first if out is non-nil.
+func (p *podmanClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+
+	url := "http://d/" + podmanAPIVersion + path
+	req, err := http.NewRequestWithContext(ctx, method, url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return raw, errNotFound{}
+	}
+	if resp.StatusCode >= 300 {
+		return raw, fmt.Errorf("podman API %s %s: %s: %s", method, path, resp.Status, string(raw))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(raw, out); err != nil {
+			return raw, err
+		}
+	}
+	return raw, nil
+}
+
+// errNotFound lets client.IsErrNotFound-style callers keep working; podman's
+// own error body shape differs from dockerd's so we normalize to a sentinel.
+type errNotFound struct{}
+
+func (errNotFound) Error() string  { return "not found" }
+func (errNotFound) NotFound() bool { return true }
+
+func (p *podmanClient) Info(ctx context.Context) (types.Info, error) {
+	var info types.Info
+	_, err := p.do(ctx, http.MethodGet, "/info", nil, &info)
+	return info, err
+}
+
+func (p *podmanClient) ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error) {
+	path := "/images/json"
+	query := make([]string, 0, 2)
+	if options.All {
+		query = append(query, "all=true")
+	}
+	if options.Filters.Len() > 0 {
+		encoded, err := filters.ToJSON(options.Filters)
+		if err != nil {
+			return nil, err
+		}
+		query = append(query, "filters="+encoded)
+	}
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+
+	var images []types.ImageSummary
+	_, err := p.do(ctx, http.MethodGet, path, nil, &images)
+	return images, err
+}
+
+func (p *podmanClient) ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error) {
+	var inspect types.ImageInspect
+	raw, err := p.do(ctx, http.MethodGet, "/images/"+image+"/json", nil, &inspect)
+	return inspect, raw, err
+}
+
+func (p *podmanClient) VolumeList(ctx context.Context, filter filters.Args) (types.VolumeListOKBody, error) {
+	var body types.VolumeListOKBody
+	path := "/volumes/json"
+	if filter.Len() > 0 {
+		encoded, err := filters.ToJSON(filter)
+		if err != nil {
+			return body, err
+		}
+		path += "?filters=" + encoded
+	}
+	_, err := p.do(ctx, http.MethodGet, path, nil, &body)
+	return body, err
+}
+
+func (p *podmanClient) ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error) {
+	path := "/containers/json"
+	query := make([]string, 0, 2)
+	if options.All {
+		query = append(query, "all=true")
+	}
+	if options.Filters.Len() > 0 {
+		encoded, err := filters.ToJSON(options.Filters)
+		if err != nil {
+			return nil, err
+		}
+		query = append(query, "filters="+encoded)
+	}
+	if len(query) > 0 {
+		path += "?" + strings.Join(query, "&")
+	}
+
+	var containers []types.Container
+	if _, err := p.do(ctx, http.MethodGet, path, nil, &containers); err != nil {
+		return nil, err
+	}
+	for i := range containers {
+		translatePodmanContainerSummary(&containers[i])
+	}
+	return containers, nil
+}
+
+// podmanInspectExtra captures the Podman-only fields tacked onto the
+// Docker-compatible inspect payload: Pod membership and, for rootless
+// Podman, the container's UID/GID mappings into the host namespace.
+type podmanInspectExtra struct {
+	Pod        string `json:"Pod"`
+	HostConfig struct {
+		IDMappings struct {
+			UIDMap []string `json:"UidMap"`
+			GIDMap []string `json:"GidMap"`
+		} `json:"IDMappings"`
+	} `json:"HostConfig"`
+}
+
+func (p *podmanClient) ContainerInspectWithRaw(ctx context.Context, id string, getSize bool) (types.ContainerJSON, []byte, error) {
+	path := "/containers/" + id + "/json"
+	if getSize {
+		path += "?size=true"
+	}
+	var container types.ContainerJSON
+	raw, err := p.do(ctx, http.MethodGet, path, nil, &container)
+	if err != nil {
+		return container, raw, err
+	}
+
+	var extra podmanInspectExtra
+	if jsonErr := json.Unmarshal(raw, &extra); jsonErr == nil {
+		translatePodmanContainerJSON(&container, &extra)
+	}
+	return container, raw, nil
+}
+
+func (p *podmanClient) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	path := "/containers/" + id + "/stats?stream=" + strconv.FormatBool(stream)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://d/"+podmanAPIVersion+path, nil)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return types.ContainerStats{}, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(resp.Body)
+		return types.ContainerStats{}, fmt.Errorf("podman stats for %s: %s: %s", id, resp.Status, string(msg))
+	}
+
+	return types.ContainerStats{Body: resp.Body, OSType: "linux"}, nil
+}
+
+func (p *podmanClient) Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error) {
+	msgCh := make(chan events.Message)
+	errCh := make(chan error, 1)
+
+	go func() {
+		defer close(msgCh)
+		defer close(errCh)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://d/"+podmanAPIVersion+"/events", nil)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			errCh <- err
+			return
+		}
+		defer resp.Body.Close()
+
+		dec := json.NewDecoder(resp.Body)
+		for {
+			var msg events.Message
+			if err := dec.Decode(&msg); err != nil {
+				if err != io.EOF {
+					errCh <- err
+				}
+				return
+			}
+			select {
+			case msgCh <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return msgCh, errCh
+}
+
+func (p *podmanClient) Close() error {
+	p.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// translatePodmanContainerSummary folds Podman-only fields from a container
+// list entry into the Labels map so callers keep using a single
+// types.Container shape regardless of runtime.
+func translatePodmanContainerSummary(c *types.Container) {
+	if pod, ok := c.Labels["io.podman.pod.name"]; ok {
+		addPodmanLabel(c.Labels, "pod", pod)
+	}
+}
+
+// translatePodmanContainerJSON folds Podman-only inspect fields (Pod
+// membership, rootless UID/GID mappings) into Config.Labels, since that is
+// the only place plugins look for runtime-specific metadata today.
+func translatePodmanContainerJSON(c *types.ContainerJSON, extra *podmanInspectExtra) {
+	if c.Config == nil {
+		return
+	}
+	if c.Config.Labels == nil {
+		c.Config.Labels = map[string]string{}
+	}
+	if extra.Pod != "" {
+		addPodmanLabel(c.Config.Labels, "pod", extra.Pod)
+	}
+	if len(extra.HostConfig.IDMappings.UIDMap) > 0 {
+		addPodmanLabel(c.Config.Labels, "rootless_uid_map", strings.Join(extra.HostConfig.IDMappings.UIDMap, ","))
+	}
+	if len(extra.HostConfig.IDMappings.GIDMap) > 0 {
+		addPodmanLabel(c.Config.Labels, "rootless_gid_map", strings.Join(extra.HostConfig.IDMappings.GIDMap, ","))
+	}
+}
+
+func addPodmanLabel(labels map[string]string, key, value string) {
+	labels["podman."+key] = strings.TrimSpace(value)
+}
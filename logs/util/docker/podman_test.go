@@ -0,0 +1,89 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	dockercontainer "github.com/docker/docker/api/types/container"
+)
+
+func TestTranslatePodmanContainerSummary(t *testing.T) {
+	cases := []struct {
+		name   string
+		labels map[string]string
+		want   map[string]string
+	}{
+		{
+			name:   "pod label present",
+			labels: map[string]string{"io.podman.pod.name": "mypod"},
+			want:   map[string]string{"io.podman.pod.name": "mypod", "podman.pod": "mypod"},
+		},
+		{
+			name:   "no pod label",
+			labels: map[string]string{"other": "value"},
+			want:   map[string]string{"other": "value"},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ctr := &types.Container{Labels: c.labels}
+			translatePodmanContainerSummary(ctr)
+			if len(ctr.Labels) != len(c.want) {
+				t.Fatalf("got labels %v, want %v", ctr.Labels, c.want)
+			}
+			for k, v := range c.want {
+				if ctr.Labels[k] != v {
+					t.Errorf("label %q = %q, want %q", k, ctr.Labels[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslatePodmanContainerJSON(t *testing.T) {
+	t.Run("nil config is a no-op", func(t *testing.T) {
+		cjson := &types.ContainerJSON{}
+		translatePodmanContainerJSON(cjson, &podmanInspectExtra{Pod: "mypod"})
+		if cjson.Config != nil {
+			t.Fatalf("expected Config to stay nil, got %+v", cjson.Config)
+		}
+	})
+
+	t.Run("folds pod and rootless id maps into labels", func(t *testing.T) {
+		cjson := &types.ContainerJSON{Config: &dockercontainer.Config{}}
+		extra := &podmanInspectExtra{Pod: "mypod"}
+		extra.HostConfig.IDMappings.UIDMap = []string{"0:1000:1"}
+		extra.HostConfig.IDMappings.GIDMap = []string{"0:1000:1"}
+
+		translatePodmanContainerJSON(cjson, extra)
+
+		want := map[string]string{
+			"podman.pod":              "mypod",
+			"podman.rootless_uid_map": "0:1000:1",
+			"podman.rootless_gid_map": "0:1000:1",
+		}
+		for k, v := range want {
+			if cjson.Config.Labels[k] != v {
+				t.Errorf("label %q = %q, want %q", k, cjson.Config.Labels[k], v)
+			}
+		}
+	})
+
+	t.Run("no extra fields leaves labels untouched", func(t *testing.T) {
+		cjson := &types.ContainerJSON{
+			Config: &dockercontainer.Config{Labels: map[string]string{"existing": "label"}},
+		}
+		translatePodmanContainerJSON(cjson, &podmanInspectExtra{})
+		if len(cjson.Config.Labels) != 1 || cjson.Config.Labels["existing"] != "label" {
+			t.Errorf("expected labels untouched, got %v", cjson.Config.Labels)
+		}
+	})
+}
@@ -0,0 +1,33 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ContainerRuntime is the set of low-level container engine operations
+// DockerUtil needs from a backend. The real docker API client (*client.Client)
+// satisfies it as-is; other engines are adapted to it by a thin wrapper, e.g.
+// podmanClient, so DockerUtil itself never has to special-case the runtime it
+// is talking to.
+type ContainerRuntime interface {
+	Info(ctx context.Context) (types.Info, error)
+	ImageList(ctx context.Context, options types.ImageListOptions) ([]types.ImageSummary, error)
+	ImageInspectWithRaw(ctx context.Context, image string) (types.ImageInspect, []byte, error)
+	VolumeList(ctx context.Context, filter filters.Args) (types.VolumeListOKBody, error)
+	ContainerList(ctx context.Context, options types.ContainerListOptions) ([]types.Container, error)
+	ContainerInspectWithRaw(ctx context.Context, id string, getSize bool) (types.ContainerJSON, []byte, error)
+	ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error)
+	Events(ctx context.Context, options types.EventsOptions) (<-chan events.Message, <-chan error)
+	Close() error
+}
@@ -0,0 +1,104 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// statsDrainPollInterval is how often Close checks whether in-flight
+// ContainerStats streams have unwound.
+const statsDrainPollInterval = 50 * time.Millisecond
+
+// Close cancels the event-stream goroutine, drains in-flight ContainerStats
+// streams, closes the underlying runtime client and clears the network
+// mapping and image name caches. Inspect results held in the shared
+// cache.Cache are not removed; they expire on their own TTL. It is safe to
+// call more than once; later calls are no-ops. ctx
+// bounds how long Close waits for in-flight stats streams to unwind.
+func (d *DockerUtil) Close(ctx context.Context) error {
+	var closeErr error
+
+	d.closeOnce.Do(func() {
+		d.eventState.Lock()
+		if d.eventState.cancel != nil {
+			d.eventState.cancel()
+		}
+		for name, sub := range d.eventState.subscribers {
+			close(sub.ch)
+			delete(d.eventState.subscribers, name)
+		}
+		d.eventState.Unlock()
+
+		d.drainStatsStreams(ctx)
+
+		d.Lock()
+		d.networkMappings = make(map[string][]dockerNetwork)
+		d.imageNameBySha = make(map[string]string)
+		d.Unlock()
+
+		if d.cli != nil {
+			closeErr = d.cli.Close()
+		}
+	})
+
+	return closeErr
+}
+
+// drainStatsStreams waits for in-flight ContainerStats streams to unwind, up
+// to ctx's deadline, so Close doesn't return while their goroutines are still
+// writing to channels nobody is reading from anymore.
+func (d *DockerUtil) drainStatsStreams(ctx context.Context) {
+	ticker := time.NewTicker(statsDrainPollInterval)
+	defer ticker.Stop()
+
+	for d.StatsStreamsInFlight() > 0 {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// TrapShutdown installs a SIGINT/SIGTERM handler that calls Close, bounding
+// it to grace, and force-exits on the third repeated signal -- the familiar
+// "3 strikes" pattern so an operator can force-kill a categraf hung on a
+// wedged docker socket.
+func (d *DockerUtil) TrapShutdown(grace time.Duration) {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		strikes := 0
+		for range sigCh {
+			strikes++
+			switch {
+			case strikes == 1:
+				log.Println("received shutdown signal, closing docker util (press twice more to force)")
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), grace)
+					defer cancel()
+					if err := d.Close(ctx); err != nil {
+						log.Printf("error closing docker util: %s", err)
+					}
+				}()
+			case strikes >= 3:
+				log.Println("received shutdown signal 3 times, forcing exit")
+				os.Exit(1)
+			default:
+				log.Println("received shutdown signal again, still closing")
+			}
+		}
+	}()
+}
@@ -0,0 +1,134 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRuntime satisfies ContainerRuntime, overriding only Close since that's
+// all Close exercises.
+type fakeRuntime struct {
+	ContainerRuntime
+	closeCalls int32
+	closeErr   error
+}
+
+func (f *fakeRuntime) Close() error {
+	atomic.AddInt32(&f.closeCalls, 1)
+	return f.closeErr
+}
+
+func newTestDockerUtilForShutdown(cli *fakeRuntime) *DockerUtil {
+	return &DockerUtil{
+		cli:             cli,
+		eventState:      newEventStreamState(),
+		networkMappings: make(map[string][]dockerNetwork),
+		imageNameBySha:  make(map[string]string),
+	}
+}
+
+func TestDrainStatsStreamsReturnsImmediatelyWhenIdle(t *testing.T) {
+	d := newTestDockerUtilForShutdown(&fakeRuntime{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.drainStatsStreams(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("drainStatsStreams did not return promptly with no in-flight streams")
+	}
+}
+
+func TestDrainStatsStreamsWaitsForInFlightToClear(t *testing.T) {
+	d := newTestDockerUtilForShutdown(&fakeRuntime{})
+	atomic.AddInt32(&d.statsInFlight, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.drainStatsStreams(ctx)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-done:
+		t.Fatal("drainStatsStreams returned before the in-flight stream cleared")
+	default:
+	}
+
+	atomic.AddInt32(&d.statsInFlight, -1)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("drainStatsStreams did not return once the in-flight stream cleared")
+	}
+}
+
+func TestDrainStatsStreamsRespectsContextDeadline(t *testing.T) {
+	d := newTestDockerUtilForShutdown(&fakeRuntime{})
+	atomic.AddInt32(&d.statsInFlight, 1)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		d.drainStatsStreams(ctx)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("drainStatsStreams did not return once ctx expired")
+	}
+}
+
+func TestCloseIsIdempotentAndClosesRuntimeOnce(t *testing.T) {
+	cli := &fakeRuntime{}
+	d := newTestDockerUtilForShutdown(cli)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("first Close: %s", err)
+	}
+	if err := d.Close(ctx); err != nil {
+		t.Fatalf("second Close: %s", err)
+	}
+	if calls := atomic.LoadInt32(&cli.closeCalls); calls != 1 {
+		t.Errorf("runtime Close called %d times, want 1", calls)
+	}
+}
+
+func TestCloseReturnsRuntimeCloseError(t *testing.T) {
+	wantErr := errors.New("boom")
+	d := newTestDockerUtilForShutdown(&fakeRuntime{closeErr: wantErr})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	if err := d.Close(ctx); !errors.Is(err, wantErr) {
+		t.Errorf("Close() error = %v, want %v", err, wantErr)
+	}
+}
@@ -0,0 +1,225 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
+
+	"github.com/docker/docker/api/types"
+)
+
+// BlkioDeviceRate holds the read/write throughput computed between two
+// consecutive blkio stats frames for a single device.
+type BlkioDeviceRate struct {
+	ReadBytesPerSec  float64
+	WriteBytesPerSec float64
+}
+
+// NetworkRate holds the rx/tx throughput computed between two consecutive
+// network stats frames for a single interface.
+type NetworkRate struct {
+	RxBytesPerSec float64
+	TxBytesPerSec float64
+}
+
+// ContainerStatsSample is a single decoded stats frame with the derived
+// values plugins actually want already computed, so each caller doesn't have
+// to reimplement the CPU%/blkio/network delta math docker's own `stats` CLI
+// does.
+type ContainerStatsSample struct {
+	Raw          *types.StatsJSON
+	CPUPercent   float64
+	BlkioRates   map[string]BlkioDeviceRate
+	NetworkRates map[string]NetworkRate
+}
+
+// StreamContainerStats keeps a stats stream open for id and decodes each
+// frame into a ContainerStatsSample. Opening a new stream is throttled by a
+// per-DockerUtil rate limiter, and the total number of streams held open at
+// once is bounded by a counting semaphore sized maxConcurrentStatsStreams, so
+// a host with hundreds of long-lived subscribers can't drift past the cap
+// over time the way a rate limiter alone would allow. Callers that hit either
+// limit get an error back and should retry later. The returned channel is
+// closed when the stream ends or ctx is done.
+func (d *DockerUtil) StreamContainerStats(ctx context.Context, id string) (<-chan *ContainerStatsSample, error) {
+	if !d.statsLimiter.Allow() {
+		atomic.AddUint64(&d.statsDropped, 1)
+		return nil, fmt.Errorf("too many new docker stats stream requests, dropping request for %s", id)
+	}
+
+	select {
+	case d.statsSem <- struct{}{}:
+	default:
+		atomic.AddUint64(&d.statsDropped, 1)
+		return nil, fmt.Errorf("too many concurrent docker stats streams, dropping request for %s", id)
+	}
+
+	stats, err := d.cli.ContainerStats(ctx, id, true)
+	if err != nil {
+		<-d.statsSem
+		return nil, fmt.Errorf("unable to open docker stats stream for %s: %s", id, err)
+	}
+
+	out := make(chan *ContainerStatsSample)
+	atomic.AddInt32(&d.statsInFlight, 1)
+
+	go func() {
+		defer close(out)
+		defer stats.Body.Close()
+		defer atomic.AddInt32(&d.statsInFlight, -1)
+		defer func() { <-d.statsSem }()
+
+		decoder := json.NewDecoder(stats.Body)
+		var prev *types.StatsJSON
+		for {
+			var frame types.StatsJSON
+			if err := decoder.Decode(&frame); err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Printf("docker stats stream for %s ended: %s", id, err)
+				}
+				return
+			}
+
+			sample := newContainerStatsSample(&frame, prev)
+			prev = &frame
+
+			select {
+			case out <- sample:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+// StatsStreamsInFlight returns the number of ContainerStats streams currently
+// open against the daemon.
+func (d *DockerUtil) StatsStreamsInFlight() int32 {
+	return atomic.LoadInt32(&d.statsInFlight)
+}
+
+// StatsStreamsDropped returns the number of StreamContainerStats calls
+// rejected so far because either the open-rate limit or the concurrency
+// limit was reached.
+func (d *DockerUtil) StatsStreamsDropped() uint64 {
+	return atomic.LoadUint64(&d.statsDropped)
+}
+
+func newContainerStatsSample(frame, prev *types.StatsJSON) *ContainerStatsSample {
+	return &ContainerStatsSample{
+		Raw:          frame,
+		CPUPercent:   cpuPercent(frame),
+		BlkioRates:   blkioRates(frame, prev),
+		NetworkRates: networkRates(frame, prev),
+	}
+}
+
+// cpuPercent reproduces the CPU% formula docker's own `stats` CLI uses:
+// the container's share of the delta in total CPU time consumed across all
+// cores since the previous sample.
+func cpuPercent(frame *types.StatsJSON) float64 {
+	cpuDelta := float64(frame.CPUStats.CPUUsage.TotalUsage) - float64(frame.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(frame.CPUStats.SystemUsage) - float64(frame.PreCPUStats.SystemUsage)
+	if systemDelta <= 0 || cpuDelta <= 0 {
+		return 0
+	}
+
+	onlineCPUs := float64(frame.CPUStats.OnlineCPUs)
+	if onlineCPUs == 0 {
+		onlineCPUs = float64(len(frame.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if onlineCPUs == 0 {
+		onlineCPUs = 1
+	}
+
+	return (cpuDelta / systemDelta) * onlineCPUs * 100.0
+}
+
+// blkioRates computes per-device byte/s read and write rates between frame
+// and prev. It returns an empty map on the first sample, since rates need
+// two points.
+func blkioRates(frame, prev *types.StatsJSON) map[string]BlkioDeviceRate {
+	rates := make(map[string]BlkioDeviceRate)
+	if prev == nil {
+		return rates
+	}
+
+	intervalSec := frame.Read.Sub(prev.Read).Seconds()
+	if intervalSec <= 0 {
+		return rates
+	}
+
+	prevBytes := blkioBytesByDevice(prev)
+	for device, cur := range blkioBytesByDevice(frame) {
+		old := prevBytes[device]
+		rates[device] = BlkioDeviceRate{
+			ReadBytesPerSec:  deltaPerSec(cur.read, old.read, intervalSec),
+			WriteBytesPerSec: deltaPerSec(cur.write, old.write, intervalSec),
+		}
+	}
+	return rates
+}
+
+type blkioBytes struct {
+	read  uint64
+	write uint64
+}
+
+func blkioBytesByDevice(frame *types.StatsJSON) map[string]blkioBytes {
+	byDevice := make(map[string]blkioBytes)
+	for _, entry := range frame.BlkioStats.IoServiceBytesRecursive {
+		key := fmt.Sprintf("%d:%d", entry.Major, entry.Minor)
+		b := byDevice[key]
+		switch entry.Op {
+		case "Read":
+			b.read = entry.Value
+		case "Write":
+			b.write = entry.Value
+		}
+		byDevice[key] = b
+	}
+	return byDevice
+}
+
+// networkRates computes per-interface rx/tx byte/s rates between frame and
+// prev. It returns an empty map on the first sample, since rates need two
+// points.
+func networkRates(frame, prev *types.StatsJSON) map[string]NetworkRate {
+	rates := make(map[string]NetworkRate)
+	if prev == nil || frame.Networks == nil {
+		return rates
+	}
+
+	intervalSec := frame.Read.Sub(prev.Read).Seconds()
+	if intervalSec <= 0 {
+		return rates
+	}
+
+	for iface, cur := range frame.Networks {
+		old := prev.Networks[iface]
+		rates[iface] = NetworkRate{
+			RxBytesPerSec: deltaPerSec(cur.RxBytes, old.RxBytes, intervalSec),
+			TxBytesPerSec: deltaPerSec(cur.TxBytes, old.TxBytes, intervalSec),
+		}
+	}
+	return rates
+}
+
+func deltaPerSec(cur, prev uint64, intervalSec float64) float64 {
+	if cur < prev {
+		return 0
+	}
+	return float64(cur-prev) / intervalSec
+}
@@ -0,0 +1,274 @@
+//go:build !no_logs
+
+// Unless explicitly stated otherwise all files in this repository are licensed
+// under the Apache License Version 2.0.
+// This product includes software developed at Datadog (https://www.datadoghq.com/).
+// Copyright 2016-present Datadog, Inc.
+
+package docker
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"golang.org/x/time/rate"
+)
+
+// fakeStatsRuntime satisfies ContainerRuntime, overriding only ContainerStats.
+// Each call hands back one end of an io.Pipe that blocks forever until the
+// test closes its writer, so the stream stays open until the test says
+// otherwise.
+type fakeStatsRuntime struct {
+	ContainerRuntime
+
+	mu      sync.Mutex
+	writers []*io.PipeWriter
+}
+
+func (f *fakeStatsRuntime) ContainerStats(ctx context.Context, id string, stream bool) (types.ContainerStats, error) {
+	pr, pw := io.Pipe()
+	f.mu.Lock()
+	f.writers = append(f.writers, pw)
+	f.mu.Unlock()
+	return types.ContainerStats{Body: pr, OSType: "linux"}, nil
+}
+
+// closeAll unblocks every outstanding stream so its goroutine can unwind.
+func (f *fakeStatsRuntime) closeAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, pw := range f.writers {
+		pw.Close()
+	}
+}
+
+func newTestDockerUtilForStats(cli *fakeStatsRuntime) *DockerUtil {
+	return &DockerUtil{
+		cli: cli,
+		// An effectively unlimited rate limiter isolates the test to the
+		// concurrency semaphore, not the separate open-rate throttle.
+		statsLimiter: rate.NewLimiter(rate.Inf, maxConcurrentStatsStreams+1),
+		statsSem:     make(chan struct{}, maxConcurrentStatsStreams),
+	}
+}
+
+func TestStreamContainerStatsCapsConcurrentStreams(t *testing.T) {
+	fr := &fakeStatsRuntime{}
+	d := newTestDockerUtilForStats(fr)
+	defer fr.closeAll()
+
+	for i := 0; i < maxConcurrentStatsStreams; i++ {
+		if _, err := d.StreamContainerStats(context.Background(), fmt.Sprintf("c%d", i)); err != nil {
+			t.Fatalf("stream %d: unexpected error: %s", i, err)
+		}
+	}
+	if got := d.StatsStreamsInFlight(); got != maxConcurrentStatsStreams {
+		t.Fatalf("StatsStreamsInFlight = %d, want %d", got, maxConcurrentStatsStreams)
+	}
+	if dropped := d.StatsStreamsDropped(); dropped != 0 {
+		t.Fatalf("StatsStreamsDropped = %d, want 0 before overflow", dropped)
+	}
+
+	_, err := d.StreamContainerStats(context.Background(), "overflow")
+	if err == nil {
+		t.Fatal("expected an error once the concurrency cap is reached")
+	}
+	if dropped := d.StatsStreamsDropped(); dropped != 1 {
+		t.Errorf("StatsStreamsDropped = %d, want 1", dropped)
+	}
+	if got := d.StatsStreamsInFlight(); got != maxConcurrentStatsStreams {
+		t.Errorf("StatsStreamsInFlight = %d, want %d (rejected stream shouldn't count)", got, maxConcurrentStatsStreams)
+	}
+}
+
+func TestStreamContainerStatsRejectsWhenRateLimited(t *testing.T) {
+	fr := &fakeStatsRuntime{}
+	d := newTestDockerUtilForStats(fr)
+	defer fr.closeAll()
+	d.statsLimiter = rate.NewLimiter(rate.Limit(0), 0)
+
+	_, err := d.StreamContainerStats(context.Background(), "c0")
+	if err == nil {
+		t.Fatal("expected an error when the open-rate limiter rejects the request")
+	}
+	if dropped := d.StatsStreamsDropped(); dropped != 1 {
+		t.Errorf("StatsStreamsDropped = %d, want 1", dropped)
+	}
+	if got := d.StatsStreamsInFlight(); got != 0 {
+		t.Errorf("StatsStreamsInFlight = %d, want 0", got)
+	}
+}
+
+func TestDeltaPerSec(t *testing.T) {
+	cases := []struct {
+		name                  string
+		cur, prev             uint64
+		intervalSec           float64
+		want                  float64
+	}{
+		{name: "normal increase", cur: 200, prev: 100, intervalSec: 2, want: 50},
+		{name: "counter reset goes to zero", cur: 10, prev: 100, intervalSec: 1, want: 0},
+		{name: "no change", cur: 100, prev: 100, intervalSec: 1, want: 0},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := deltaPerSec(c.cur, c.prev, c.intervalSec); got != c.want {
+				t.Errorf("deltaPerSec(%d, %d, %v) = %v, want %v", c.cur, c.prev, c.intervalSec, got, c.want)
+			}
+		})
+	}
+}
+
+func TestCPUPercent(t *testing.T) {
+	cases := []struct {
+		name  string
+		frame *types.StatsJSON
+		want  float64
+	}{
+		{
+			name: "typical delta across 2 online cpus",
+			frame: &types.StatsJSON{
+				Stats: types.Stats{
+					CPUStats: types.CPUStats{
+						CPUUsage:   types.CPUUsage{TotalUsage: 300},
+						SystemUsage: 1000,
+						OnlineCPUs: 2,
+					},
+					PreCPUStats: types.CPUStats{
+						CPUUsage:   types.CPUUsage{TotalUsage: 200},
+						SystemUsage: 800,
+					},
+				},
+			},
+			want: (100.0 / 200.0) * 2 * 100.0,
+		},
+		{
+			name: "no system delta returns zero",
+			frame: &types.StatsJSON{
+				Stats: types.Stats{
+					CPUStats:    types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 300}, SystemUsage: 800},
+					PreCPUStats: types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 200}, SystemUsage: 800},
+				},
+			},
+			want: 0,
+		},
+		{
+			name: "falls back to percpu count when online cpus is unset",
+			frame: &types.StatsJSON{
+				Stats: types.Stats{
+					CPUStats: types.CPUStats{
+						CPUUsage:    types.CPUUsage{TotalUsage: 300, PercpuUsage: []uint64{1, 2, 3}},
+						SystemUsage: 1000,
+					},
+					PreCPUStats: types.CPUStats{CPUUsage: types.CPUUsage{TotalUsage: 200}, SystemUsage: 800},
+				},
+			},
+			want: (100.0 / 200.0) * 3 * 100.0,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := cpuPercent(c.frame); got != c.want {
+				t.Errorf("cpuPercent() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestBlkioRates(t *testing.T) {
+	t.Run("first sample returns empty map", func(t *testing.T) {
+		frame := &types.StatsJSON{Stats: types.Stats{Read: time.Unix(10, 0)}}
+		if rates := blkioRates(frame, nil); len(rates) != 0 {
+			t.Errorf("expected empty map, got %v", rates)
+		}
+	})
+
+	t.Run("computes read/write rates between samples", func(t *testing.T) {
+		prev := &types.StatsJSON{
+			Stats: types.Stats{
+				Read: time.Unix(10, 0),
+				BlkioStats: types.BlkioStats{
+					IoServiceBytesRecursive: []types.BlkioStatEntry{
+						{Major: 8, Minor: 0, Op: "Read", Value: 1000},
+						{Major: 8, Minor: 0, Op: "Write", Value: 500},
+					},
+				},
+			},
+		}
+		frame := &types.StatsJSON{
+			Stats: types.Stats{
+				Read: time.Unix(12, 0),
+				BlkioStats: types.BlkioStats{
+					IoServiceBytesRecursive: []types.BlkioStatEntry{
+						{Major: 8, Minor: 0, Op: "Read", Value: 3000},
+						{Major: 8, Minor: 0, Op: "Write", Value: 900},
+					},
+				},
+			},
+		}
+
+		rates := blkioRates(frame, prev)
+		rate, ok := rates["8:0"]
+		if !ok {
+			t.Fatalf("expected rate for device 8:0, got %v", rates)
+		}
+		if rate.ReadBytesPerSec != 1000 || rate.WriteBytesPerSec != 200 {
+			t.Errorf("got %+v, want ReadBytesPerSec=1000 WriteBytesPerSec=200", rate)
+		}
+	})
+
+	t.Run("non-positive interval returns empty map", func(t *testing.T) {
+		prev := &types.StatsJSON{Stats: types.Stats{Read: time.Unix(10, 0)}}
+		frame := &types.StatsJSON{Stats: types.Stats{Read: time.Unix(10, 0)}}
+		if rates := blkioRates(frame, prev); len(rates) != 0 {
+			t.Errorf("expected empty map, got %v", rates)
+		}
+	})
+}
+
+func TestNetworkRates(t *testing.T) {
+	t.Run("first sample returns empty map", func(t *testing.T) {
+		frame := &types.StatsJSON{Stats: types.Stats{Read: time.Unix(10, 0)}}
+		if rates := networkRates(frame, nil); len(rates) != 0 {
+			t.Errorf("expected empty map, got %v", rates)
+		}
+	})
+
+	t.Run("computes rx/tx rates between samples", func(t *testing.T) {
+		prev := &types.StatsJSON{
+			Stats:    types.Stats{Read: time.Unix(10, 0)},
+			Networks: map[string]types.NetworkStats{"eth0": {RxBytes: 1000, TxBytes: 500}},
+		}
+		frame := &types.StatsJSON{
+			Stats:    types.Stats{Read: time.Unix(11, 0)},
+			Networks: map[string]types.NetworkStats{"eth0": {RxBytes: 2000, TxBytes: 800}},
+		}
+
+		rates := networkRates(frame, prev)
+		rate, ok := rates["eth0"]
+		if !ok {
+			t.Fatalf("expected rate for eth0, got %v", rates)
+		}
+		if rate.RxBytesPerSec != 1000 || rate.TxBytesPerSec != 300 {
+			t.Errorf("got %+v, want RxBytesPerSec=1000 TxBytesPerSec=300", rate)
+		}
+	})
+
+	t.Run("nil networks on current frame returns empty map", func(t *testing.T) {
+		prev := &types.StatsJSON{
+			Stats:    types.Stats{Read: time.Unix(10, 0)},
+			Networks: map[string]types.NetworkStats{"eth0": {RxBytes: 1000}},
+		}
+		frame := &types.StatsJSON{Stats: types.Stats{Read: time.Unix(11, 0)}}
+		if rates := networkRates(frame, prev); len(rates) != 0 {
+			t.Errorf("expected empty map, got %v", rates)
+		}
+	})
+}